@@ -5,7 +5,9 @@
 package kintone
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -19,6 +21,7 @@ import (
 	"net/textproto"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -53,7 +56,9 @@ func (e *AppError) Error() string {
 
 // App provides kintone application API client.
 //
-// You need to provide Domain, User, Password, and AppId.
+// You need to provide Domain, User, Password, and AppId, or set Auth
+// to an Authenticator (PasswordAuth, APITokenAuth, or OAuth2Auth) for
+// the modern token-based alternatives.
 // When using Google AppEngine, you must supply Client too.
 //
 //	import (
@@ -78,6 +83,8 @@ type App struct {
 	AppId             uint64        // application ID.
 	Client            *http.Client  // Specialized client.
 	Timeout           time.Duration // Timeout for API responses.
+	Retry             *RetryPolicy  // Retry policy for transient errors.  nil disables retries.
+	Auth              Authenticator // Authentication method.  Overrides User/Password/token when set.
 	token             string        // auth token.
 	basicAuth         bool          // true to use Basic Authentication.
 	basicAuthUser     string        // User name for Basic Authentication.
@@ -93,28 +100,55 @@ func (app *App) SetBasicAuth(user, password string) {
 }
 
 func (app *App) newRequest(method, api string, body io.Reader) (*http.Request, error) {
-	if len(app.token) == 0 {
-		app.token = base64.StdEncoding.EncodeToString(
-			[]byte(app.User + ":" + app.Password))
-	}
+	return app.newRequestContext(context.Background(), method, api, body)
+}
+
+func (app *App) newRequestContext(ctx context.Context, method, api string, body io.Reader) (*http.Request, error) {
 	u := url.URL{
 		Scheme: "https",
 		Host:   app.Domain,
 		Path:   "/k/v1/" + api + ".json",
 	}
-	req, err := http.NewRequest(method, u.String(), body)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
 	if err != nil {
 		return nil, err
 	}
 	if app.basicAuth {
 		req.SetBasicAuth(app.basicAuthUser, app.basicAuthPassword)
 	}
-	req.Header.Set("X-Cybozu-Authorization", app.token)
+	if app.Auth != nil {
+		if err := app.Auth.Apply(req); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(app.token) == 0 {
+			app.token = base64.StdEncoding.EncodeToString(
+				[]byte(app.User + ":" + app.Password))
+		}
+		req.Header.Set("X-Cybozu-Authorization", app.token)
+	}
 	req.Header.Set("Content-Type", "application/json")
 	return req, nil
 }
 
+// do sends req and waits for a response, applying app.Timeout if one
+// has not already been set by the caller's context.
+//
+// Deprecated: do is kept for callers that have not migrated to the
+// *Context methods yet.  It derives its context from
+// context.Background(), so it cannot be cancelled by the caller.
 func (app *App) do(req *http.Request) (*http.Response, error) {
+	return app.doContext(context.Background(), req)
+}
+
+// doContext is the context-aware counterpart of do.  It cancels the
+// in-flight request as soon as ctx is done, either because the caller
+// cancelled it or because app.Timeout elapsed, and never leaves the
+// request goroutine running past that point.
+//
+// If app.Retry is set, the request is retried per its policy; see
+// doWithRetry.
+func (app *App) doContext(ctx context.Context, req *http.Request) (*http.Response, error) {
 	if app.Client == nil {
 		jar, err := cookiejar.New(nil)
 		if err != nil {
@@ -126,35 +160,48 @@ func (app *App) do(req *http.Request) (*http.Response, error) {
 		app.Timeout = DEFAULT_TIMEOUT
 	}
 
-	type result struct {
-		resp *http.Response
-		err  error
+	cancel := func() {}
+	if app.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, app.Timeout)
 	}
-	done := make(chan result, 1)
-	go func() {
-		resp, err := app.Client.Do(req)
-		done <- result{resp, err}
-	}()
+	defer cancel()
+	req = req.WithContext(ctx)
 
-	type requestCanceler interface {
-		CancelRequest(*http.Request)
+	if app.Retry != nil {
+		return app.doWithRetry(ctx, req, app.Retry)
 	}
+	return app.doOnce(ctx, req)
+}
+
+// doOnce performs a single HTTP round trip for req, which must already
+// carry ctx.
+func (app *App) doOnce(ctx context.Context, req *http.Request) (*http.Response, error) {
+	done := make(chan struct{})
+	var resp *http.Response
+	var err error
+	go func() {
+		defer close(done)
+		resp, err = app.Client.Do(req)
+	}()
 
 	select {
-	case r := <-done:
-		return r.resp, r.err
-	case <-time.After(app.Timeout):
-		if canceller, ok := app.Client.Transport.(requestCanceler); ok {
-			canceller.CancelRequest(req)
-		} else {
-			go func() {
-				r := <-done
-				if r.err == nil {
-					r.resp.Body.Close()
-				}
-			}()
+	case <-done:
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimeout
+			}
+			return nil, err
+		}
+		return resp, nil
+	case <-ctx.Done():
+		<-done
+		if resp != nil {
+			resp.Body.Close()
 		}
-		return nil, ErrTimeout
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
 	}
 }
 
@@ -166,40 +213,62 @@ func isJSON(contentType string) bool {
 	return mediatype == "application/json"
 }
 
-func parseResponse(resp *http.Response) ([]byte, error) {
-	body, err := ioutil.ReadAll(resp.Body)
+// readAppResponse reads and closes resp's body, and for any response
+// other than 200 OK builds the *AppError it carries.  Unlike parseResponse, it
+// hands back the raw body alongside that error so a caller that needs
+// more than the message/id/code triple — such as ExecuteContext
+// picking the failing op out of a bulkRequest error — doesn't have to
+// re-implement the read/status/isJSON dance to get at it.
+func readAppResponse(resp *http.Response) (body []byte, ae *AppError, err error) {
+	body, err = ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return body, nil, nil
+	}
+	if !isJSON(resp.Header.Get("Content-Type")) {
+		return body, &AppError{
+			HttpStatus:     resp.Status,
+			HttpStatusCode: resp.StatusCode,
+		}, nil
+	}
+	var e AppError
+	json.Unmarshal(body, &e)
+	e.HttpStatus = resp.Status
+	e.HttpStatusCode = resp.StatusCode
+	return body, &e, nil
+}
+
+func parseResponse(resp *http.Response) ([]byte, error) {
+	body, ae, err := readAppResponse(resp)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		if !isJSON(resp.Header.Get("Content-Type")) {
-			return nil, &AppError{
-				HttpStatus:     resp.Status,
-				HttpStatusCode: resp.StatusCode,
-			}
-		}
-		var ae AppError
-		json.Unmarshal(body, &ae)
-		ae.HttpStatus = resp.Status
-		ae.HttpStatusCode = resp.StatusCode
-		return nil, &ae
+	if ae != nil {
+		return nil, ae
 	}
 	return body, nil
 }
 
 // GetRecord fetches a record.
 func (app *App) GetRecord(id uint64) (Record, error) {
+	return app.GetRecordContext(context.Background(), id)
+}
+
+// GetRecordContext is the context-aware version of GetRecord.
+func (app *App) GetRecordContext(ctx context.Context, id uint64) (Record, error) {
 	type request_body struct {
 		App uint64 `json:"app,string"`
 		Id  uint64 `json:"id,string"`
 	}
 	data, _ := json.Marshal(request_body{app.AppId, id})
-	req, err := app.newRequest("GET", "record", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "GET", "record", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -224,26 +293,41 @@ func (app *App) GetRecord(id uint64) (Record, error) {
 // If fields is nil, all fields are retrieved.
 // See API specs how to construct query strings.
 func (app *App) GetRecords(fields []string, query string) ([]Record, error) {
+	return app.GetRecordsContext(context.Background(), fields, query)
+}
+
+// GetRecordsContext is the context-aware version of GetRecords.
+//
+// The response is decoded as it streams in, so fetching a large
+// result set does not double peak memory the way reading the whole
+// body before parsing it would.
+func (app *App) GetRecordsContext(ctx context.Context, fields []string, query string) ([]Record, error) {
 	type request_body struct {
 		App    uint64   `json:"app,string"`
 		Fields []string `json:"fields"`
 		Query  string   `json:"query"`
 	}
 	data, _ := json.Marshal(request_body{app.AppId, fields, query})
-	req, err := app.newRequest("GET", "records", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "GET", "records", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	body, err := parseResponse(resp)
-	if err != nil {
+	defer resp.Body.Close()
+
+	br := bufio.NewReader(resp.Body)
+	if err := checkStatus(resp, br); err != nil {
 		return nil, err
 	}
-	recs, err := DecodeRecords(body)
-	if err != nil {
+
+	recs := make([]Record, 0, 100)
+	if err := DecodeRecordsStream(br, func(rec Record) error {
+		recs = append(recs, rec)
+		return nil
+	}); err != nil {
 		return nil, ErrInvalidResponse
 	}
 	return recs, nil
@@ -253,39 +337,20 @@ func (app *App) GetRecords(fields []string, query string) ([]Record, error) {
 //
 // If fields is nil, all fields are retrieved.
 func (app *App) GetAllRecords(fields []string) ([]Record, error) {
+	return app.GetAllRecordsContext(context.Background(), fields)
+}
+
+// GetAllRecordsContext is the context-aware version of GetAllRecords.
+func (app *App) GetAllRecordsContext(ctx context.Context, fields []string) ([]Record, error) {
 	recs := make([]Record, 0, 100)
-	type request_body struct {
-		App    uint64   `json:"app,string"`
-		Fields []string `json:"fields"`
-		Query  string   `json:"query"`
-	}
-	for {
-		query := "limit 100"
-		if len(recs) > 0 {
-			query = fmt.Sprintf("limit 100 offset %v", len(recs))
-		}
-		data, _ := json.Marshal(request_body{app.AppId, fields, query})
-		req, err := app.newRequest("GET", "records", bytes.NewReader(data))
-		if err != nil {
-			return nil, err
-		}
-		resp, err := app.do(req)
-		if err != nil {
-			return nil, err
-		}
-		body, err := parseResponse(resp)
-		if err != nil {
-			return nil, err
-		}
-		r, err := DecodeRecords(body)
-		if err != nil {
-			return nil, ErrInvalidResponse
-		}
-		recs = append(recs, r...)
-		if len(r) < 100 {
-			return recs, nil
-		}
+	err := app.RangeRecords(ctx, fields, "", func(rec Record) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return recs, nil
 }
 
 // FileData stores downloaded file data.
@@ -298,15 +363,20 @@ type FileData struct {
 //
 // fileKey should be obtained from FileField (= []File).
 func (app *App) Download(fileKey string) (*FileData, error) {
+	return app.DownloadContext(context.Background(), fileKey)
+}
+
+// DownloadContext is the context-aware version of Download.
+func (app *App) DownloadContext(ctx context.Context, fileKey string) (*FileData, error) {
 	type request_body struct {
 		FileKey string `json:"fileKey"`
 	}
 	data, _ := json.Marshal(request_body{fileKey})
-	req, err := app.newRequest("GET", "file", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "GET", "file", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -349,37 +419,45 @@ func escapeQuotes(s string) string {
 //
 // If successfully uploaded, the key string of the uploaded file is returned.
 func (app *App) Upload(fileName, contentType string, data io.Reader) (key string, err error) {
-	f, err := ioutil.TempFile("", "hoge")
-	if err != nil {
-		return
-	}
-	defer func(fn string) {
-		_ = os.Remove(fn)
-	}(f.Name())
-
-	w := multipart.NewWriter(f)
-	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition",
-		fmt.Sprintf(`form-data; name="file"; filename="%s"`,
-			escapeQuotes(fileName)))
-	h.Set("Content-Type", contentType)
-	fw, err := w.CreatePart(h)
-	if _, err = io.Copy(fw, data); err != nil {
-		return
-	}
-	if err = w.Close(); err != nil {
-		return
-	}
-	if _, err = f.Seek(0, 0); err != nil {
-		return
-	}
+	return app.UploadContext(context.Background(), fileName, contentType, data)
+}
 
-	req, err := app.newRequest("POST", "file", f)
+// UploadContext is the context-aware version of Upload.
+//
+// The multipart body is streamed directly from data through an
+// io.Pipe, rather than being buffered on disk first.
+func (app *App) UploadContext(ctx context.Context, fileName, contentType string, data io.Reader) (key string, err error) {
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+
+	go func() {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="file"; filename="%s"`,
+				escapeQuotes(fileName)))
+		h.Set("Content-Type", contentType)
+		fw, err := w.CreatePart(h)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(fw, data); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := w.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	req, err := app.newRequestContext(ctx, "POST", "file", pr)
 	if err != nil {
 		return
 	}
 	req.Header.Set("Content-Type", w.FormDataContentType())
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return
 	}
@@ -398,20 +476,55 @@ func (app *App) Upload(fileName, contentType string, data io.Reader) (key string
 	return t.FileKey, nil
 }
 
+// UploadFromFile uploads the file at path, sniffing its content type
+// from the first 512 bytes and then streaming the whole file directly
+// from disk.
+func (app *App) UploadFromFile(path string) (key string, err error) {
+	return app.UploadFromFileContext(context.Background(), path)
+}
+
+// UploadFromFileContext is the context-aware version of
+// UploadFromFile.
+func (app *App) UploadFromFileContext(ctx context.Context, path string) (key string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var sniff [512]byte
+	n, err := io.ReadFull(f, sniff[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return
+	}
+	err = nil
+	contentType := http.DetectContentType(sniff[:n])
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	return app.UploadContext(ctx, filepath.Base(path), contentType, f)
+}
+
 // AddRecord adds a new record.
 //
 // If successful, the record ID of the new record is returned.
 func (app *App) AddRecord(rec Record) (id string, err error) {
+	return app.AddRecordContext(context.Background(), rec)
+}
+
+// AddRecordContext is the context-aware version of AddRecord.
+func (app *App) AddRecordContext(ctx context.Context, rec Record) (id string, err error) {
 	type request_body struct {
 		App    uint64 `json:"app,string"`
 		Record Record `json:"record"`
 	}
 	data, _ := json.Marshal(request_body{app.AppId, rec})
-	req, err := app.newRequest("POST", "record", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "POST", "record", bytes.NewReader(data))
 	if err != nil {
 		return
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return
 	}
@@ -436,6 +549,11 @@ func (app *App) AddRecord(rec Record) (id string, err error) {
 // Up to 100 records can be added at once.
 // If successful, a list of record IDs is returned.
 func (app *App) AddRecords(recs []Record) ([]string, error) {
+	return app.AddRecordsContext(context.Background(), recs)
+}
+
+// AddRecordsContext is the context-aware version of AddRecords.
+func (app *App) AddRecordsContext(ctx context.Context, recs []Record) ([]string, error) {
 	if len(recs) > 100 {
 		return nil, ErrTooMany
 	}
@@ -445,11 +563,11 @@ func (app *App) AddRecords(recs []Record) ([]string, error) {
 		Records []Record `json:"records"`
 	}
 	data, _ := json.Marshal(request_body{app.AppId, recs})
-	req, err := app.newRequest("POST", "records", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "POST", "records", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -469,17 +587,22 @@ func (app *App) AddRecords(recs []Record) ([]string, error) {
 
 // UpdateRecord edits a record.
 func (app *App) UpdateRecord(id uint64, rec Record) error {
+	return app.UpdateRecordContext(context.Background(), id, rec)
+}
+
+// UpdateRecordContext is the context-aware version of UpdateRecord.
+func (app *App) UpdateRecordContext(ctx context.Context, id uint64, rec Record) error {
 	type request_body struct {
 		App    uint64 `json:"app,string"`
 		Id     uint64 `json:"id,string"`
 		Record Record `json:"record"`
 	}
 	data, _ := json.Marshal(request_body{app.AppId, id, rec})
-	req, err := app.newRequest("PUT", "record", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "PUT", "record", bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -492,6 +615,11 @@ func (app *App) UpdateRecord(id uint64, rec Record) error {
 // "recs" is a mapping between record IDs and Record data.
 // Up to 100 records can be edited at once.
 func (app *App) UpdateRecords(recs map[uint64]Record) error {
+	return app.UpdateRecordsContext(context.Background(), recs)
+}
+
+// UpdateRecordsContext is the context-aware version of UpdateRecords.
+func (app *App) UpdateRecordsContext(ctx context.Context, recs map[uint64]Record) error {
 	if len(recs) > 100 {
 		return ErrTooMany
 	}
@@ -509,11 +637,11 @@ func (app *App) UpdateRecords(recs map[uint64]Record) error {
 		t_recs = append(t_recs, update_t{id, rec})
 	}
 	data, _ := json.Marshal(request_body{app.AppId, t_recs})
-	req, err := app.newRequest("PUT", "records", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "PUT", "records", bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -525,6 +653,11 @@ func (app *App) UpdateRecords(recs map[uint64]Record) error {
 //
 // Up to 100 records can be deleted at once.
 func (app *App) DeleteRecords(ids []uint64) error {
+	return app.DeleteRecordsContext(context.Background(), ids)
+}
+
+// DeleteRecordsContext is the context-aware version of DeleteRecords.
+func (app *App) DeleteRecordsContext(ctx context.Context, ids []uint64) error {
 	if len(ids) > 100 {
 		return ErrTooMany
 	}
@@ -534,11 +667,11 @@ func (app *App) DeleteRecords(ids []uint64) error {
 		Ids []uint64 `json:"ids,string"`
 	}
 	data, _ := json.Marshal(request_body{app.AppId, ids})
-	req, err := app.newRequest("DELETE", "records", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "DELETE", "records", bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -608,15 +741,20 @@ func (fi *FieldInfo) UnmarshalJSON(data []byte) error {
 //
 // If successful, a mapping between field codes and FieldInfo is returned.
 func (app *App) Fields() (map[string]*FieldInfo, error) {
+	return app.FieldsContext(context.Background())
+}
+
+// FieldsContext is the context-aware version of Fields.
+func (app *App) FieldsContext(ctx context.Context) (map[string]*FieldInfo, error) {
 	type request_body struct {
 		App uint64 `json:"app,string"`
 	}
 	data, _ := json.Marshal(request_body{app.AppId})
-	req, err := app.newRequest("GET", "form", bytes.NewReader(data))
+	req, err := app.newRequestContext(ctx, "GET", "form", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	resp, err := app.do(req)
+	resp, err := app.doContext(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,78 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrAuthConflict is returned by OAuth2Auth.Apply when the request
+// already carries an Authorization header, which happens when
+// App.SetBasicAuth was also called: both write that same header, and
+// one would silently clobber the other.
+var ErrAuthConflict = errors.New("kintone: OAuth2Auth cannot be combined with HTTP Basic authentication")
+
+// Authenticator applies credentials to an outgoing request.  Set
+// App.Auth to an Authenticator to use it instead of the legacy
+// User/Password/token fields.
+type Authenticator interface {
+	Apply(req *http.Request) error
+}
+
+// PasswordAuth authenticates with a kintone user's password, the same
+// way App.User/App.Password do.
+type PasswordAuth struct {
+	User     string
+	Password string
+}
+
+// Apply implements Authenticator.
+func (a PasswordAuth) Apply(req *http.Request) error {
+	token := base64.StdEncoding.EncodeToString([]byte(a.User + ":" + a.Password))
+	req.Header.Set("X-Cybozu-Authorization", token)
+	return nil
+}
+
+// APITokenAuth authenticates with one or more per-app API tokens.
+// kintone allows up to 3 tokens in a single request, comma-joined, to
+// call an API that spans multiple apps.
+type APITokenAuth struct {
+	Tokens []string
+}
+
+// Apply implements Authenticator.
+func (a APITokenAuth) Apply(req *http.Request) error {
+	req.Header.Set("X-Cybozu-API-Token", strings.Join(a.Tokens, ","))
+	return nil
+}
+
+// OAuth2Auth authenticates with an OAuth2 bearer token, as supported
+// on cybozu.com.  TokenSource is consulted on every request, so it
+// transparently refreshes an expired access token.
+//
+// OAuth2Auth cannot be combined with App.SetBasicAuth: both write the
+// Authorization header, so Apply fails with ErrAuthConflict rather
+// than silently dropping one of the two credentials.
+type OAuth2Auth struct {
+	TokenSource oauth2.TokenSource
+}
+
+// Apply implements Authenticator.
+func (a OAuth2Auth) Apply(req *http.Request) error {
+	if req.Header.Get("Authorization") != "" {
+		return ErrAuthConflict
+	}
+	tok, err := a.TokenSource.Token()
+	if err != nil {
+		return err
+	}
+	tok.SetAuthHeader(req)
+	return nil
+}
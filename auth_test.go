@@ -0,0 +1,70 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestPasswordAuthApply(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.cybozu.com/k/v1/record.json", nil)
+	auth := PasswordAuth{User: "alice", Password: "secret"}
+	if err := auth.Apply(req); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got := req.Header.Get("X-Cybozu-Authorization"); got == "" {
+		t.Error("X-Cybozu-Authorization header not set")
+	}
+}
+
+func TestAPITokenAuthApply(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.cybozu.com/k/v1/record.json", nil)
+	auth := APITokenAuth{Tokens: []string{"tok1", "tok2"}}
+	if err := auth.Apply(req); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	want := "tok1,tok2"
+	if got := req.Header.Get("X-Cybozu-API-Token"); got != want {
+		t.Errorf("X-Cybozu-API-Token = %q, want %q", got, want)
+	}
+}
+
+type staticTokenSource struct {
+	tok *oauth2.Token
+	err error
+}
+
+func (s staticTokenSource) Token() (*oauth2.Token, error) {
+	return s.tok, s.err
+}
+
+func TestOAuth2AuthApply(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.cybozu.com/k/v1/record.json", nil)
+	auth := OAuth2Auth{TokenSource: staticTokenSource{tok: &oauth2.Token{AccessToken: "abc123"}}}
+	if err := auth.Apply(req); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got, want := req.Header.Get("Authorization"), "Bearer abc123"; got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestOAuth2AuthConflictsWithBasicAuth(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.cybozu.com/k/v1/record.json", nil)
+	req.SetBasicAuth("user", "pass")
+
+	auth := OAuth2Auth{TokenSource: staticTokenSource{tok: &oauth2.Token{AccessToken: "abc123"}}}
+	err := auth.Apply(req)
+	if !errors.Is(err, ErrAuthConflict) {
+		t.Fatalf("err = %v, want ErrAuthConflict", err)
+	}
+	if got, want := req.Header.Get("Authorization"), "Basic"; got == "" || got[:len(want)] != want {
+		t.Errorf("Authorization = %q, want it to remain the Basic auth value", got)
+	}
+}
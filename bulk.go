@@ -0,0 +1,210 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// maxBulkRequests is the largest number of operations kintone allows
+// in a single bulkRequest.json call.
+const maxBulkRequests = 20
+
+// ErrBulkTooMany is returned by App.Execute when a BulkRequest holds
+// more than 20 operations.
+var ErrBulkTooMany = errors.New("Too many bulk requests")
+
+var bulkErrorIndex = regexp.MustCompile(`^requests\[(\d+)\]`)
+
+type bulkRequestEntry struct {
+	Method  string      `json:"method"`
+	Api     string      `json:"api"`
+	Payload interface{} `json:"payload"`
+}
+
+// BulkRequest builds up to 20 record operations, across one or more
+// apps, to be executed atomically by App.Execute.
+type BulkRequest struct {
+	entries []bulkRequestEntry
+}
+
+// NewBulkRequest creates an empty BulkRequest.
+func NewBulkRequest() *BulkRequest {
+	return &BulkRequest{}
+}
+
+func (br *BulkRequest) add(method, api string, payload interface{}) {
+	br.entries = append(br.entries, bulkRequestEntry{method, api, payload})
+}
+
+// AddRecord appends an operation that adds a new record to appID.
+func (br *BulkRequest) AddRecord(appID uint64, rec Record) {
+	type request_body struct {
+		App    uint64 `json:"app,string"`
+		Record Record `json:"record"`
+	}
+	br.add("POST", "/k/v1/record.json", request_body{appID, rec})
+}
+
+// AddRecords appends an operation that adds new records to appID.
+//
+// Up to 100 records can be added by one operation.
+func (br *BulkRequest) AddRecords(appID uint64, recs []Record) {
+	type request_body struct {
+		App     uint64   `json:"app,string"`
+		Records []Record `json:"records"`
+	}
+	br.add("POST", "/k/v1/records.json", request_body{appID, recs})
+}
+
+// UpdateRecord appends an operation that edits a record in appID.
+//
+// revision is the expected revision of the record; pass -1 to skip
+// the revision check.
+func (br *BulkRequest) UpdateRecord(appID, id uint64, rec Record, revision int) {
+	type request_body struct {
+		App      uint64 `json:"app,string"`
+		Id       uint64 `json:"id,string"`
+		Record   Record `json:"record"`
+		Revision int    `json:"revision,string"`
+	}
+	br.add("PUT", "/k/v1/record.json", request_body{appID, id, rec, revision})
+}
+
+// UpdateRecords appends an operation that edits multiple records in
+// appID.
+//
+// "recs" is a mapping between record IDs and Record data.  Up to 100
+// records can be edited by one operation.
+func (br *BulkRequest) UpdateRecords(appID uint64, recs map[uint64]Record) {
+	type update_t struct {
+		Id     uint64 `json:"id,string"`
+		Record Record `json:"record"`
+	}
+	type request_body struct {
+		App     uint64     `json:"app,string"`
+		Records []update_t `json:"records"`
+	}
+	t_recs := make([]update_t, 0, len(recs))
+	for id, rec := range recs {
+		t_recs = append(t_recs, update_t{id, rec})
+	}
+	br.add("PUT", "/k/v1/records.json", request_body{appID, t_recs})
+}
+
+// DeleteRecords appends an operation that deletes multiple records
+// from appID.
+//
+// Up to 100 records can be deleted by one operation.
+func (br *BulkRequest) DeleteRecords(appID uint64, ids []uint64) {
+	type request_body struct {
+		App uint64   `json:"app,string"`
+		Ids []uint64 `json:"ids,string"`
+	}
+	br.add("DELETE", "/k/v1/records.json", request_body{appID, ids})
+}
+
+// BulkResult holds the outcome of a single operation within a
+// BulkRequest.  Each field is set only when the operation's result
+// carries the matching key, so which ones are populated depends on
+// the operation's kind: Id (and Revision) for AddRecord, Ids for
+// AddRecords, Revision for UpdateRecord, Revisions for UpdateRecords.
+// DeleteRecords operations leave BulkResult empty.
+type BulkResult struct {
+	Id        string   `json:"id"`
+	Ids       []string `json:"ids"`
+	Revision  string   `json:"revision"`
+	Revisions []string `json:"revisions"`
+}
+
+// BulkError reports that one operation within a BulkRequest failed,
+// rolling back the whole atomic batch.  Index is the position of the
+// failing operation within the BulkRequest.
+type BulkError struct {
+	Index int
+	*AppError
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("bulk request failed at index %d: %s", e.Index, e.AppError.Error())
+}
+
+// Execute sends br to kintone's bulkRequest.json endpoint, which
+// applies every operation it holds atomically: if any one of them
+// fails, none of them are committed.
+//
+// On success, the returned slice has one BulkResult per operation, in
+// the order they were added to br.  On a partial failure, the error
+// is a *BulkError identifying which operation kintone rolled back on.
+func (app *App) Execute(br *BulkRequest) ([]BulkResult, error) {
+	return app.ExecuteContext(context.Background(), br)
+}
+
+// ExecuteContext is the context-aware version of Execute.
+func (app *App) ExecuteContext(ctx context.Context, br *BulkRequest) ([]BulkResult, error) {
+	if len(br.entries) > maxBulkRequests {
+		return nil, ErrBulkTooMany
+	}
+
+	type request_body struct {
+		Requests []bulkRequestEntry `json:"requests"`
+	}
+	data, _ := json.Marshal(request_body{br.entries})
+	req, err := app.newRequestContext(ctx, "POST", "bulkRequest", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := app.doContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, ae, err := readAppResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if ae != nil {
+		var details struct {
+			Errors map[string]json.RawMessage `json:"errors"`
+		}
+		json.Unmarshal(body, &details)
+		// Map iteration order is randomized; when more than one
+		// requests[N]... key is present, report the lowest index so
+		// BulkError.Index is deterministic across identical responses.
+		index := -1
+		for key := range details.Errors {
+			if m := bulkErrorIndex.FindStringSubmatch(key); m != nil {
+				i, _ := strconv.Atoi(m[1])
+				if index == -1 || i < index {
+					index = i
+				}
+			}
+		}
+		if index >= 0 {
+			return nil, &BulkError{Index: index, AppError: ae}
+		}
+		return nil, ae
+	}
+
+	var t struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if json.Unmarshal(body, &t) != nil {
+		return nil, ErrInvalidResponse
+	}
+	results := make([]BulkResult, len(t.Results))
+	for i, raw := range t.Results {
+		if json.Unmarshal(raw, &results[i]) != nil {
+			return nil, ErrInvalidResponse
+		}
+	}
+	return results, nil
+}
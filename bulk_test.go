@@ -0,0 +1,131 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExecuteSuccess(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"id":"1","revision":"1"},{"revision":"3"},{}]}`))
+	})
+	defer srv.Close()
+
+	br := NewBulkRequest()
+	br.AddRecord(1, Record{})
+	br.UpdateRecord(1, 2, Record{}, -1)
+	br.DeleteRecords(1, []uint64{2})
+
+	results, err := app.Execute(br)
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("len(results) = %d, want 3", len(results))
+	}
+	if results[0].Id != "1" {
+		t.Errorf("results[0].Id = %q, want %q", results[0].Id, "1")
+	}
+	if results[1].Revision != "3" {
+		t.Errorf("results[1].Revision = %q, want %q", results[1].Revision, "3")
+	}
+}
+
+func TestExecuteTooMany(t *testing.T) {
+	br := NewBulkRequest()
+	for i := 0; i < maxBulkRequests+1; i++ {
+		br.AddRecord(1, Record{})
+	}
+	app := &App{Domain: "example.cybozu.com", AppId: 1}
+	if _, err := app.Execute(br); err != ErrBulkTooMany {
+		t.Errorf("err = %v, want ErrBulkTooMany", err)
+	}
+}
+
+// TestExecutePartialFailure pins ExecuteContext's handling of a
+// rolled-back atomic batch against the "errors" envelope kintone's
+// validation-error responses document, keyed by field path
+// (https://cybozu.dev/ja/kintone/docs/rest-api/records/add-records/):
+// a bulkRequest failure nests the same shape under "requests[N]...".
+// This is our best-documented approximation of that response, not a
+// capture of a live kintone failure; treat BulkError.Index accordingly.
+func TestExecutePartialFailure(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{
+			"code": "CB_VA01",
+			"id": "abcdef",
+			"message": "Invalid request.",
+			"errors": {
+				"requests[1].record.field.value": {
+					"messages": ["This field is required."]
+				}
+			}
+		}`))
+	})
+	defer srv.Close()
+
+	br := NewBulkRequest()
+	br.AddRecord(1, Record{})
+	br.AddRecord(1, Record{})
+
+	_, err := app.Execute(br)
+	be, ok := err.(*BulkError)
+	if !ok {
+		t.Fatalf("err = %v (%T), want *BulkError", err, err)
+	}
+	if be.Index != 1 {
+		t.Errorf("be.Index = %d, want 1", be.Index)
+	}
+	if be.Code != "CB_VA01" {
+		t.Errorf("be.Code = %q, want %q", be.Code, "CB_VA01")
+	}
+}
+
+// TestExecutePartialFailureMultipleIndicesIsDeterministic guards
+// against picking details.Errors' keys by map iteration order: when
+// more than one requests[N]... key fails, BulkError.Index must land
+// on the same (lowest) index every time, not whichever key the
+// runtime happened to visit first.
+func TestExecutePartialFailureMultipleIndicesIsDeterministic(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{
+			"code": "CB_VA01",
+			"id": "abcdef",
+			"message": "Invalid request.",
+			"errors": {
+				"requests[3].record.field.value": {
+					"messages": ["This field is required."]
+				},
+				"requests[1].record.field.value": {
+					"messages": ["This field is required."]
+				}
+			}
+		}`))
+	})
+	defer srv.Close()
+
+	br := NewBulkRequest()
+	for i := 0; i < 4; i++ {
+		br.AddRecord(1, Record{})
+	}
+
+	for i := 0; i < 20; i++ {
+		_, err := app.Execute(br)
+		be, ok := err.(*BulkError)
+		if !ok {
+			t.Fatalf("err = %v (%T), want *BulkError", err, err)
+		}
+		if be.Index != 1 {
+			t.Fatalf("be.Index = %d, want 1 (run %d)", be.Index, i)
+		}
+	}
+}
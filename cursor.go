@@ -0,0 +1,194 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// maxCursorSize is the largest page size kintone allows per fetch from
+// a record cursor.
+const maxCursorSize = 500
+
+// RecordCursor walks the records matching a query via kintone's
+// record cursor API (POST/GET/DELETE records/cursor.json), which has
+// no offset ceiling unlike GetRecords' "limit ... offset ..." query.
+//
+// A RecordCursor must be closed with Close once it is no longer
+// needed, to release the server-side cursor before it expires.
+type RecordCursor struct {
+	app    *App
+	ctx    context.Context
+	id     string
+	buf    []Record
+	done   bool
+	closed bool
+}
+
+// NewRecordCursor creates a cursor over the records matching query.
+//
+// If fields is nil, all fields are retrieved.  size controls how many
+// records are fetched per request (and is clamped to 500, the server
+// maximum); 0 selects the maximum.
+func (app *App) NewRecordCursor(fields []string, query string, size int) (*RecordCursor, error) {
+	return app.NewRecordCursorContext(context.Background(), fields, query, size)
+}
+
+// NewRecordCursorContext is the context-aware version of NewRecordCursor.
+func (app *App) NewRecordCursorContext(ctx context.Context, fields []string, query string, size int) (*RecordCursor, error) {
+	if size <= 0 || size > maxCursorSize {
+		size = maxCursorSize
+	}
+
+	type request_body struct {
+		App    uint64   `json:"app,string"`
+		Fields []string `json:"fields"`
+		Query  string   `json:"query"`
+		Size   int      `json:"size,string"`
+	}
+	data, _ := json.Marshal(request_body{app.AppId, fields, query, size})
+	req, err := app.newRequestContext(ctx, "POST", "records/cursor", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := app.doContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var t struct {
+		Id string `json:"id"`
+	}
+	if decodeStream(resp, &t) != nil {
+		return nil, ErrInvalidResponse
+	}
+	return &RecordCursor{app: app, ctx: ctx, id: t.Id}, nil
+}
+
+// Next returns the next record, or io.EOF once the cursor is
+// exhausted.
+func (c *RecordCursor) Next() (Record, error) {
+	if len(c.buf) == 0 {
+		recs, err := c.NextBatch()
+		if err != nil {
+			return nil, err
+		}
+		c.buf = recs
+	}
+	rec := c.buf[0]
+	c.buf = c.buf[1:]
+	return rec, nil
+}
+
+// NextBatch fetches and returns the next page of records, or io.EOF
+// once the cursor is exhausted.
+func (c *RecordCursor) NextBatch() ([]Record, error) {
+	if c.done {
+		return nil, io.EOF
+	}
+
+	type request_body struct {
+		Id string `json:"id"`
+	}
+	data, _ := json.Marshal(request_body{c.id})
+	req, err := c.app.newRequestContext(c.ctx, "GET", "records/cursor", bytes.NewReader(data))
+	if err != nil {
+		c.abort()
+		return nil, err
+	}
+	resp, err := c.app.doContext(c.ctx, req)
+	if err != nil {
+		c.abort()
+		return nil, err
+	}
+
+	var t struct {
+		Records []json.RawMessage `json:"records"`
+		Next    bool              `json:"next"`
+	}
+	if decodeStream(resp, &t) != nil {
+		c.abort()
+		return nil, ErrInvalidResponse
+	}
+	if !t.Next {
+		c.done = true
+	}
+	if len(t.Records) == 0 {
+		return nil, io.EOF
+	}
+
+	recs := make([]Record, len(t.Records))
+	for i, raw := range t.Records {
+		rec, err := DecodeRecord(raw)
+		if err != nil {
+			c.abort()
+			return nil, ErrInvalidResponse
+		}
+		recs[i] = rec
+	}
+	return recs, nil
+}
+
+// Close releases the server-side cursor.  It is safe to call Close
+// more than once, and safe to call on a cursor that has already run
+// to completion.
+func (c *RecordCursor) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	type request_body struct {
+		Id string `json:"id"`
+	}
+	data, _ := json.Marshal(request_body{c.id})
+	req, err := c.app.newRequestContext(c.ctx, "DELETE", "records/cursor", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := c.app.doContext(c.ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = parseResponse(resp)
+	return err
+}
+
+// abort marks the cursor exhausted and makes a best-effort attempt to
+// release the server-side cursor after a terminal error.
+func (c *RecordCursor) abort() {
+	c.done = true
+	c.Close()
+}
+
+// RangeRecords walks every record matching query, calling fn for each
+// one as it is fetched.  It is a convenience wrapper around
+// RecordCursor that guarantees the cursor is closed, even if fn or the
+// walk itself returns an error.
+//
+// If fields is nil, all fields are retrieved.
+func (app *App) RangeRecords(ctx context.Context, fields []string, query string, fn func(Record) error) error {
+	cur, err := app.NewRecordCursorContext(ctx, fields, query, maxCursorSize)
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+
+	for {
+		rec, err := cur.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestRangeRecordsPagination(t *testing.T) {
+	var gets, deletes int
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "POST":
+			w.Write([]byte(`{"id":"cur1"}`))
+		case "GET":
+			gets++
+			switch gets {
+			case 1:
+				w.Write([]byte(`{"records":[{"id":{"value":"1"}},{"id":{"value":"2"}}],"next":true}`))
+			case 2:
+				w.Write([]byte(`{"records":[{"id":{"value":"3"}}],"next":false}`))
+			default:
+				w.Write([]byte(`{"records":[],"next":false}`))
+			}
+		case "DELETE":
+			deletes++
+			w.Write([]byte(`{}`))
+		}
+	})
+	defer srv.Close()
+
+	var ids []string
+	err := app.RangeRecords(context.Background(), nil, "", func(rec Record) error {
+		ids = append(ids, rec["id"].(map[string]interface{})["value"].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RangeRecords failed: %v", err)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("len(ids) = %d, want 3: %v", len(ids), ids)
+	}
+	if deletes != 1 {
+		t.Errorf("deletes = %d, want 1", deletes)
+	}
+}
+
+func TestRecordCursorNextEOF(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "POST":
+			w.Write([]byte(`{"id":"cur2"}`))
+		case "GET":
+			w.Write([]byte(`{"records":[],"next":false}`))
+		case "DELETE":
+			w.Write([]byte(`{}`))
+		}
+	})
+	defer srv.Close()
+
+	cur, err := app.NewRecordCursor(nil, "", 10)
+	if err != nil {
+		t.Fatalf("NewRecordCursor failed: %v", err)
+	}
+	defer cur.Close()
+
+	if _, err := cur.Next(); err != io.EOF {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestRecordCursorAbortsOnBatchError(t *testing.T) {
+	var deletes int
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"cur3"}`))
+		case "GET":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "DELETE":
+			deletes++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		}
+	})
+	defer srv.Close()
+
+	cur, err := app.NewRecordCursor(nil, "", 10)
+	if err != nil {
+		t.Fatalf("NewRecordCursor failed: %v", err)
+	}
+
+	if _, err := cur.Next(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if deletes != 1 {
+		t.Errorf("deletes after abort = %d, want 1", deletes)
+	}
+
+	// Close after an abort must be a no-op, not a second DELETE.
+	if err := cur.Close(); err != nil {
+		t.Errorf("Close after abort: %v", err)
+	}
+	if deletes != 1 {
+		t.Errorf("deletes after Close = %d, want 1", deletes)
+	}
+}
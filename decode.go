@@ -0,0 +1,119 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+func isJSONPeek(br *bufio.Reader) bool {
+	b, err := br.Peek(1)
+	return err == nil && len(b) > 0 && b[0] == '{'
+}
+
+// checkStatus converts a non-2xx response into an *AppError,
+// distinguishing kintone's JSON error envelopes from the raw
+// HTML/text some proxies return by checking the Content-Type header
+// and, failing that, peeking the first byte of the body.
+func checkStatus(resp *http.Response, br *bufio.Reader) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if !isJSON(resp.Header.Get("Content-Type")) && !isJSONPeek(br) {
+		return &AppError{
+			HttpStatus:     resp.Status,
+			HttpStatusCode: resp.StatusCode,
+		}
+	}
+	var ae AppError
+	json.NewDecoder(br).Decode(&ae)
+	ae.HttpStatus = resp.Status
+	ae.HttpStatusCode = resp.StatusCode
+	return &ae
+}
+
+// decodeStream decodes resp's body straight into v with a streaming
+// JSON decoder instead of buffering the whole response first, so peak
+// memory stays proportional to what json.Decoder needs rather than to
+// the payload size.
+func decodeStream(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	if err := checkStatus(resp, br); err != nil {
+		return err
+	}
+	return json.NewDecoder(br).Decode(v)
+}
+
+// DecodeRecordsStream walks the top-level "records" array of r — the
+// shape returned by GetRecords and the record cursor — calling fn for
+// each record as it is parsed.  It never materializes the full slice.
+// GetRecords uses it; the record cursor does not, and decodes each
+// page into a slice of records before handing them out.
+// DecodeRecords is implemented on top of this for backward
+// compatibility.
+func DecodeRecordsStream(r io.Reader, fn func(Record) error) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	if err := seekRecordsArray(dec); err != nil {
+		return err
+	}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		rec, err := DecodeRecord(raw)
+		if err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeRecords parses the "records" array in b into a slice.  It is
+// kept for callers that have not migrated to DecodeRecordsStream, and
+// is implemented on top of it so both share the same decoding
+// behavior.
+func DecodeRecords(b []byte) ([]Record, error) {
+	var recs []Record
+	err := DecodeRecordsStream(bytes.NewReader(b), func(rec Record) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// seekRecordsArray advances dec past the "records" key so the caller
+// can decode the array elements one at a time.
+func seekRecordsArray(dec *json.Decoder) error {
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok || key != "records" {
+			continue
+		}
+		tok, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if _, ok := tok.(json.Delim); !ok {
+			return ErrInvalidResponse
+		}
+		return nil
+	}
+}
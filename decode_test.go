@@ -0,0 +1,142 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDecodeStreamSuccess(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1","revision":"2"}`))
+	})
+	defer srv.Close()
+
+	req, err := app.newRequest("GET", "record", nil)
+	if err != nil {
+		t.Fatalf("newRequest failed: %v", err)
+	}
+	resp, err := app.do(req)
+	if err != nil {
+		t.Fatalf("do failed: %v", err)
+	}
+
+	var t_ struct {
+		Id       string `json:"id"`
+		Revision string `json:"revision"`
+	}
+	if err := decodeStream(resp, &t_); err != nil {
+		t.Fatalf("decodeStream failed: %v", err)
+	}
+	if t_.Id != "1" || t_.Revision != "2" {
+		t.Errorf("got %+v", t_)
+	}
+}
+
+// TestDecodeStreamJSONError exercises checkStatus' Content-Type path:
+// a non-2xx response whose body is a kintone JSON error envelope.
+func TestDecodeStreamJSONError(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message":"bad query","code":"CB_VA01","id":"xyz"}`))
+	})
+	defer srv.Close()
+
+	req, err := app.newRequest("GET", "record", nil)
+	if err != nil {
+		t.Fatalf("newRequest failed: %v", err)
+	}
+	resp, err := app.do(req)
+	if err != nil {
+		t.Fatalf("do failed: %v", err)
+	}
+
+	var t_ struct{}
+	err = decodeStream(resp, &t_)
+	ae, ok := err.(*AppError)
+	if !ok {
+		t.Fatalf("err = %v (%T), want *AppError", err, err)
+	}
+	if ae.Code != "CB_VA01" {
+		t.Errorf("ae.Code = %q, want %q", ae.Code, "CB_VA01")
+	}
+}
+
+// TestDecodeStreamNonJSONError exercises checkStatus' fallback path:
+// a non-2xx response with no (or non-JSON) Content-Type, e.g. an HTML
+// error page from a proxy in front of kintone.
+func TestDecodeStreamNonJSONError(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`<html><body>Bad Gateway</body></html>`))
+	})
+	defer srv.Close()
+
+	req, err := app.newRequest("GET", "record", nil)
+	if err != nil {
+		t.Fatalf("newRequest failed: %v", err)
+	}
+	resp, err := app.do(req)
+	if err != nil {
+		t.Fatalf("do failed: %v", err)
+	}
+
+	var t_ struct{}
+	err = decodeStream(resp, &t_)
+	ae, ok := err.(*AppError)
+	if !ok {
+		t.Fatalf("err = %v (%T), want *AppError", err, err)
+	}
+	if ae.HttpStatusCode != http.StatusBadGateway {
+		t.Errorf("ae.HttpStatusCode = %d, want %d", ae.HttpStatusCode, http.StatusBadGateway)
+	}
+	if ae.Message != "" {
+		t.Errorf("ae.Message = %q, want empty", ae.Message)
+	}
+}
+
+func TestDecodeRecordsStream(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"records":[{"a":1},{"a":2},{"a":3}]}`))
+	})
+	defer srv.Close()
+
+	req, err := app.newRequest("GET", "records", nil)
+	if err != nil {
+		t.Fatalf("newRequest failed: %v", err)
+	}
+	resp, err := app.do(req)
+	if err != nil {
+		t.Fatalf("do failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var got []float64
+	err = DecodeRecordsStream(resp.Body, func(rec Record) error {
+		got = append(got, rec["a"].(float64))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DecodeRecordsStream failed: %v", err)
+	}
+	if len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Errorf("got %v, want [1 2 3]", got)
+	}
+}
+
+func TestDecodeRecords(t *testing.T) {
+	recs, err := DecodeRecords([]byte(`{"records":[{"a":1},{"a":2}]}`))
+	if err != nil {
+		t.Fatalf("DecodeRecords failed: %v", err)
+	}
+	if len(recs) != 2 || recs[0]["a"].(float64) != 1 || recs[1]["a"].(float64) != 2 {
+		t.Errorf("got %v", recs)
+	}
+}
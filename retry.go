@@ -0,0 +1,159 @@
+// (C) 2014 Cybozu.  All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file.
+
+package kintone
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how App retries requests that fail with a
+// transient error: a network error, a 429 (kintone's per-domain rate
+// limit, including GAIA_TM12 throttling), or a 500, 502, 503 or 504
+// response.
+//
+// Set App.Retry to enable retries; DefaultRetryPolicy returns sensible
+// defaults.
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts, including the first; <1 means 1.
+	InitialBackoff time.Duration // backoff before the first retry.
+	MaxBackoff     time.Duration // backoff is capped at this value.
+	Multiplier     float64       // backoff growth factor per attempt.
+	Jitter         float64       // fraction of the backoff to randomize, in [0,1].
+
+	// RetryOn reports whether a request should be retried given the
+	// response (nil on network failure) and error from an attempt.
+	// If nil, the default policy retries network errors, 429, and
+	// 500/502/503/504.
+	RetryOn func(*http.Response, error) bool
+}
+
+// DefaultRetryPolicy returns a RetryPolicy with sensible defaults: 5
+// attempts, 500ms to 30s exponential backoff with a factor of 2 and
+// 20% jitter.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+func defaultRetryOn(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryOn(resp *http.Response, err error) bool {
+	if p.RetryOn != nil {
+		return p.RetryOn(resp, err)
+	}
+	return defaultRetryOn(resp, err)
+}
+
+// backoff returns the delay before the attempt-th retry (0-based),
+// full-jittered: sleep = min(MaxBackoff, InitialBackoff*Multiplier^attempt) * (1 - Jitter + rand*Jitter*2).
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt))
+	if max := float64(p.MaxBackoff); d > max {
+		d = max
+	}
+	factor := (1 - p.Jitter) + rand.Float64()*p.Jitter*2
+	return time.Duration(d * factor)
+}
+
+// retryAfterDelay parses a Retry-After header in either delta-seconds
+// or HTTP-date form.
+func retryAfterDelay(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// doWithRetry runs req through policy, retrying transient failures.
+// req must already carry ctx.
+//
+// Most requests this package builds go through
+// http.NewRequestWithContext with a *bytes.Reader body, which
+// populates req.GetBody automatically, so a fresh, fully-seeked body
+// is obtained from there for each attempt rather than type-asserting
+// req.Body against io.Seeker (http.NewRequestWithContext always wraps
+// Body in an io.ReadCloser, which drops that assertion even for an
+// underlying reader that does support it). A request whose body
+// can't be replayed this way — req.GetBody is nil, as for Upload's
+// io.Pipe body — is never retried,
+// since resending it risks a truncated payload; the last response is
+// returned to the caller with its body left open, not closed out from
+// under it.
+func (app *App) doWithRetry(ctx context.Context, req *http.Request, policy *RetryPolicy) (*http.Response, error) {
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return nil, berr
+			}
+			req.Body = body
+		}
+
+		resp, err = app.doOnce(ctx, req)
+		if !policy.retryOn(resp, err) || attempt == maxAttempts-1 {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := policy.backoff(attempt)
+		if resp != nil {
+			if d, ok := retryAfterDelay(resp); ok {
+				delay = d
+			}
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrTimeout
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,114 @@
+package kintone
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestApp(t *testing.T, handler http.HandlerFunc) (*App, *httptest.Server) {
+	t.Helper()
+	srv := httptest.NewTLSServer(handler)
+	app := &App{
+		Domain: strings.TrimPrefix(srv.URL, "https://"),
+		AppId:  1,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+	return app, srv
+}
+
+func TestRetrySucceedsAfterTransientFailures(t *testing.T) {
+	var attempts int
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1"}`))
+	})
+	defer srv.Close()
+
+	app.Retry = &RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+	}
+
+	id, err := app.AddRecord(Record{})
+	if err != nil {
+		t.Fatalf("AddRecord failed: %v", err)
+	}
+	if id != "1" {
+		t.Errorf("id = %q, want %q", id, "1")
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestRetryReturnsRealErrorWhenExhausted(t *testing.T) {
+	var attempts int
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message":"down for maintenance","code":"GAIA_MA01","id":"abc"}`))
+	})
+	defer srv.Close()
+
+	app.Retry = &RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+	}
+
+	_, err := app.AddRecord(Record{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	ae, ok := err.(*AppError)
+	if !ok {
+		t.Fatalf("err = %v (%T), want *AppError", err, err)
+	}
+	if ae.Code != "GAIA_MA01" {
+		t.Errorf("ae.Code = %q, want %q", ae.Code, "GAIA_MA01")
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+// TestRetryDeadlineDuringBackoffIsErrTimeout pins that a deadline
+// expiring in doWithRetry's inter-attempt sleep surfaces as
+// ErrTimeout, the same translation doOnce applies, not a raw
+// context.DeadlineExceeded.
+func TestRetryDeadlineDuringBackoffIsErrTimeout(t *testing.T) {
+	app, srv := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer srv.Close()
+
+	app.Timeout = 50 * time.Millisecond
+	app.Retry = &RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+		Multiplier:     1,
+	}
+
+	_, err := app.AddRecord(Record{})
+	if err != ErrTimeout {
+		t.Fatalf("err = %v (%T), want ErrTimeout", err, err)
+	}
+}